@@ -2,11 +2,7 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"log"
-	"os"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,30 +10,28 @@ import (
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
-	"github.com/libp2p/go-libp2p-core/test"
 	kaddht "github.com/libp2p/go-libp2p-kad-dht"
+	noise "github.com/libp2p/go-libp2p-noise"
+	quic "github.com/libp2p/go-libp2p-quic-transport"
 	routing "github.com/libp2p/go-libp2p-routing"
 	secio "github.com/libp2p/go-libp2p-secio"
+	tls "github.com/libp2p/go-libp2p-tls"
 	"github.com/libp2p/go-tcp-transport"
+	ws "github.com/libp2p/go-ws-transport"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/syndtr/goleveldb/leveldb"
 )
 
-// SeenNode struct
-// TODO: More info from seen nodes could be extracted.
-type SeenNode struct {
-	NAT      bool
-	lastSeen string
-}
-
 // Crawler node structure
 type Crawler struct {
-	host   host.Host
-	ctx    context.Context
-	cancel context.CancelFunc
-	dht    *kaddht.IpfsDHT
-	db     *leveldb.DB
-	mux    *sync.Mutex
+	host       host.Host
+	ctx        context.Context
+	cancel     context.CancelFunc
+	dht        *kaddht.IpfsDHT
+	db         *leveldb.DB
+	mux        *sync.Mutex
+	relayNodes []peer.AddrInfo
+	federation *FederationPeer
 }
 
 // Creates a new crawler node.
@@ -57,7 +51,7 @@ func newCrawler(db *leveldb.DB, mux *sync.Mutex) *Crawler {
 }
 
 // Liveliness process to check if nodes are still alive.
-func (c *Crawler) liveliness(verbose bool) {
+func (c *Crawler) liveliness() {
 	// for {
 	select {
 	// Return if context done.
@@ -71,8 +65,11 @@ func (c *Crawler) liveliness(verbose bool) {
 			key := string(iter.Key())
 			value := string(iter.Value())
 			if len(strings.Split(key, ".")) == 1 {
-				// Used to check if behind NAT or not.
-				var canConnectErr error
+				if c.federation != nil && c.federation.recentlyConfirmedReachable(key) {
+					// Another crawler already confirmed this peer
+					// reachable recently; skip our own probe this round.
+					continue
+				}
 
 				// Test connection of found nodes
 				pString := fmt.Sprintf("/p2p/%s", key)
@@ -85,22 +82,24 @@ func (c *Crawler) liveliness(verbose bool) {
 				pInfo, err := peer.AddrInfoFromP2pAddr(p)
 
 				// fmt.Println("Checking if alive ", pInfo)
-				canConnectErr = c.ephemeralConnection(pInfo)
+				path, canConnectErr := c.ephemeralConnection(pInfo)
 				// if canConnectErr == nil {
 				// 	fmt.Println("Connected peer", pInfo.String())
 				// }
 
-				var node SeenNode
-				json.Unmarshal([]byte(value), &node)
-				timestamp := strconv.FormatInt(time.Now().UTC().UnixNano(), 10)
+				node, err := unmarshalNodeRecord([]byte(value))
+				if err != nil {
+					logger.Warnw("Dropping unreadable record", "phase", phaseLiveliness, "peer", key, "err", err)
+					continue
+				}
+
+				wasReachable := wasConfirmedReachable(node.Reachability)
 
 				// If we could see the node but not anymore it means is out.
 				// c.mux.Lock()
-				if node.NAT == false && canConnectErr != nil {
+				if wasReachable && canConnectErr != nil {
 					c.mux.Lock()
-					if verbose {
-						log.Println("[Liveliness] Node left:", key, node, canConnectErr)
-					}
+					logger.Infow("Node left", "phase", phaseLiveliness, "peer", key, "nat", node.Reachability.String(), "err", canConnectErr)
 					c.updateCount(fmt.Sprintf("%s.left", currentDate()), true)
 					// c.updateCount(fmt.Sprintf("%s.count", currentDate()), false)
 					c.updateCount("total.count", false)
@@ -110,14 +109,15 @@ func (c *Crawler) liveliness(verbose bool) {
 					c.db.Delete([]byte(key), nil)
 					c.mux.Unlock()
 				} else {
-					// If node already seen only update lastSeen
-					node.lastSeen = timestamp
-					if canConnectErr != nil {
-						node.NAT = true
-					} else {
-						node.NAT = false
-					}
-					c.storeSeenNode(key, node)
+					// If node already seen only update lastSeen and
+					// reachability, classified by which NAT-traversal
+					// strategy actually produced this connection (or
+					// none) rather than the old binary "did Connect
+					// succeed" heuristic.
+					node.Reachability = reachabilityForPath(path)
+					node.Path = path
+					node.touch()
+					c.signAndStore(key, node)
 				}
 				// c.mux.Unlock()
 			}
@@ -128,21 +128,53 @@ func (c *Crawler) liveliness(verbose bool) {
 	// }
 }
 
-// Initializes a crawling node.
-func (c *Crawler) initCrawler(BootstrapNodes []string, verbose bool) {
+// defaultListenAddrs is used when initCrawler is not given an explicit
+// list. It covers TCP, QUIC, and WebSocket so the crawler can dial (and be
+// dialed by) the large fraction of the network that no longer advertises
+// plain TCP.
+var defaultListenAddrs = []string{
+	"/ip4/0.0.0.0/tcp/0",
+	"/ip4/0.0.0.0/udp/0/quic-v1",
+	"/ip4/0.0.0.0/tcp/0/ws",
+}
+
+// Initializes a crawling node. FederationTopic is opt-in: leave it empty
+// to run standalone, or set it to a shared topic name to gossip
+// discoveries with other crawler instances on that topic. LogLevel sets
+// the crawler logger's verbosity (trace/debug/info/warn); an empty
+// LogLevel leaves it at go-log's default. The flag itself (e.g.
+// --loglevel) is parsed by the command's entry point, which isn't part of
+// this package.
+func (c *Crawler) initCrawler(BootstrapNodes []string, RelayNodes []string, ListenAddrs []string, useSecio bool, FederationTopic string, LogLevel string) {
+
+	if LogLevel != "" {
+		setLogLevel(LogLevel)
+	}
+
+	c.relayNodes = relayAddrInfos(RelayNodes)
 
 	transports := libp2p.ChainOptions(
 		libp2p.Transport(tcp.NewTCPTransport),
-		// TODO: Add more transport interfaces for enhanced connectivity??
-		// libp2p.Transport()
+		libp2p.Transport(quic.NewTransport),
+		libp2p.Transport(ws.New),
 	)
 
-	security := libp2p.Security(secio.ID, secio.New)
+	// Noise and TLS are the default security stack; secio is deprecated
+	// and only kept around for peers that haven't upgraded yet.
+	var security libp2p.Option
+	if useSecio {
+		security = libp2p.Security(secio.ID, secio.New)
+	} else {
+		security = libp2p.ChainOptions(
+			libp2p.Security(noise.ID, noise.New),
+			libp2p.Security(tls.ID, tls.New),
+		)
+	}
 
-	// Listen TCP on any available port.
-	listenAddrs := libp2p.ListenAddrStrings(
-		"/ip4/0.0.0.0/tcp/0",
-	)
+	if len(ListenAddrs) == 0 {
+		ListenAddrs = defaultListenAddrs
+	}
+	listenAddrs := libp2p.ListenAddrStrings(ListenAddrs...)
 
 	//TODO: Share DHT by all crawlers for faster discovery?
 	newDHT := func(h host.Host) (routing.PeerRouting, error) {
@@ -152,6 +184,18 @@ func (c *Crawler) initCrawler(BootstrapNodes []string, verbose bool) {
 	}
 
 	routing := libp2p.Routing(newDHT)
+
+	// NAT traversal: find out whether we are publicly reachable (AutoNAT
+	// client), allow dialing peers through circuit-v2 relays when a
+	// direct dial fails, and let DCUtR try to upgrade a relayed
+	// connection to a direct one.
+	natTraversal := libp2p.ChainOptions(
+		libp2p.EnableAutoNAT(),
+		libp2p.EnableAutoRelayWithStaticRelays(c.relayNodes),
+		libp2p.EnableRelay(),
+		libp2p.EnableHolePunching(),
+	)
+
 	var err error
 	c.host, err = libp2p.New(
 		c.ctx,
@@ -159,6 +203,7 @@ func (c *Crawler) initCrawler(BootstrapNodes []string, verbose bool) {
 		listenAddrs,
 		security,
 		routing,
+		natTraversal,
 	)
 	if err != nil {
 		panic(err)
@@ -168,6 +213,19 @@ func (c *Crawler) initCrawler(BootstrapNodes []string, verbose bool) {
 	// 	fmt.Println("Listening on", addr)
 	// }
 
+	// Drive node-record updates off identify rather than raw Connect
+	// results from here on.
+	c.startIdentifyConsumer()
+
+	if FederationTopic != "" {
+		federation, err := newFederationPeer(c.ctx, c, FederationTopic)
+		if err != nil {
+			logger.Warnw("Could not start federation peer", "phase", phaseFederation, "err", err)
+		} else {
+			c.federation = federation
+		}
+	}
+
 	// Create routingDiscovery
 	// c.routingDisc = disc.NewRoutingDiscovery(c.dht)
 
@@ -186,10 +244,8 @@ func (c *Crawler) initCrawler(BootstrapNodes []string, verbose bool) {
 		err = c.host.Connect(c.ctx, *pInfo)
 
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "connecting to bootstrap: %s\n", err)
-		} // else {
-		// 	fmt.Println("Connected to bootstrap", pInfo.ID)
-		// }
+			logger.Warnw("Connecting to bootstrap", "phase", phaseBootstrap, "peer", pInfo.ID, "err", err)
+		}
 
 		// Node in bootstrapped state. Ready to crawl.
 		err = c.dht.Bootstrap(c.ctx)
@@ -198,7 +254,7 @@ func (c *Crawler) initCrawler(BootstrapNodes []string, verbose bool) {
 		}
 	}
 
-	fmt.Println("Crawler has been bootstrapped...")
+	logger.Info("Crawler has been bootstrapped...")
 
 	for {
 		select {
@@ -208,114 +264,127 @@ func (c *Crawler) initCrawler(BootstrapNodes []string, verbose bool) {
 			c.close()
 			return
 		default:
-			// Start random walk
-			c.randomWalk(verbose)
+			// Pull from every discovery source and probe in parallel.
+			c.crawl()
 		}
 	}
 }
 
-// Random DHT walk performed by crawler.
-func (c *Crawler) randomWalk(verbose bool) {
-
-	// Choose a random ID
-	id, err := test.RandPeerID()
-	if err != nil {
-		panic(err)
+// crawlWorkers bounds how many peers are probed concurrently per crawl
+// pass.
+const crawlWorkers = 32
+
+// crawl mixes every configured peer-source Iterator into one FairMix and
+// dispatches each discovered peer to a bounded pool of workers that run
+// ephemeralConnection, identify, and the DB update concurrently. This
+// replaces the old "one DHT query, one peer at a time" loop with a
+// saturated pipeline.
+func (c *Crawler) crawl() {
+	mix := NewFairMix(
+		newRandomWalkIterator(c),
+		newPeerstoreIterator(c),
+	)
+	defer mix.Close()
+
+	jobs := make(chan peer.AddrInfo)
+	var wg sync.WaitGroup
+	for i := 0; i < crawlWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pInfo := range jobs {
+				c.probePeer(pInfo)
+			}
+		}()
 	}
-	key := id.String()
 
-	// Start crawling from key starting from random node.
-	c.crawlFromKey(key, verbose)
+	for {
+		pInfo, ok := mix.Next(c.ctx)
+		if !ok {
+			break
+		}
+		select {
+		case jobs <- pInfo:
+		case <-c.ctx.Done():
+			close(jobs)
+			wg.Wait()
+			return
+		}
+	}
+	close(jobs)
+	wg.Wait()
 }
 
-func (c *Crawler) crawlFromKey(key string, verbose bool) {
-
-	// Make 60 seconds crawls
-	ctx, cancel := context.WithTimeout(c.ctx, timeClosestPeers*time.Second)
-	pch, _ := c.dht.GetClosestPeers(ctx, key)
-
-	// No peers found
-	cancel()
-
-	var ps []peer.ID
-	for p := range pch {
-		ps = append(ps, p)
+// probePeer dials a single peer discovered by any source and records
+// which NAT-traversal strategy actually worked (if any) via
+// reachabilityForPath; richer enrichment (addrs, protocols, agent
+// version) happens asynchronously in onIdentifyCompleted/onIdentifyFailed
+// once identify has actually run for the peer.
+func (c *Crawler) probePeer(pInfo peer.AddrInfo) {
+	if c.federation != nil && c.federation.recentlyConfirmedReachable(pInfo.ID.String()) {
+		// Another crawler already confirmed this peer reachable recently.
+		return
 	}
 
-	// log.Printf("Found %d peers", len(ps))
-	for _, pID := range ps {
+	path, canConnectErr := c.ephemeralConnection(&pInfo)
 
-		// Used to check if behind NAT or not.
-		var canConnectErr error
+	// Enforce atomic update
+	c.mux.Lock()
+	defer c.mux.Unlock()
 
-		// Test connection of found nodes
-		pString := fmt.Sprintf("/p2p/%s", pID.String())
-
-		// fmt.Println(pString)
-		p, err := multiaddr.NewMultiaddr(pString)
-		if err != nil {
-			panic(err)
+	// If the key is empty in db we haven't seen it.
+	stored, err := c.getSeenNode(pInfo.ID.String())
+	if err != nil || stored == nil {
+		if canConnectErr != nil {
+			// Couldn't connect and identify never ran: nothing to record.
+			return
 		}
 
-		pInfo, err := peer.AddrInfoFromP2pAddr(p)
+		node := newNodeRecord(pInfo.ID)
+		node.Reachability = reachabilityForPath(path)
+		node.Path = path
+		// Store node in database
+		c.signAndStore(pInfo.ID.String(), node)
+		// Update counters
+		c.updateCount(fmt.Sprintf("%s.count", currentDate()), true)
+		c.updateCount("total.count", true)
+		logger.Debugw("New node", "phase", phaseRandomWalk, "peer", pInfo.ID, "nat", node.Reachability.String(), "result", string(node.Path))
 
-		// fmt.Println("Trying ", pInfo)
-		canConnectErr = c.ephemeralConnection(pInfo)
-		// if canConnectErr == nil {
-		// 	fmt.Println("Connected peer", pInfo.String())
-		// }
-
-		var aux SeenNode
-		timestamp := strconv.FormatInt(time.Now().UTC().UnixNano(), 10)
-
-		// Enforce atomic update
-		c.mux.Lock()
-		// If the key is empty in db we haven't seen it.
-		if stored, _ := c.getSeenNode(pID.String()); stored == aux {
-			hasNat := false
-			if canConnectErr != nil {
-				hasNat = true
-			}
-			aux = SeenNode{NAT: hasNat, lastSeen: timestamp}
-			// Store node in database
-			c.storeSeenNode(pID.String(), aux)
-			// Update counters
-			c.updateCount(fmt.Sprintf("%s.count", currentDate()), true)
-			c.updateCount("total.count", true)
-			if verbose {
-				log.Println("[Random Walk] New Node: ", pID.String(), aux)
-			}
+		return
+	}
 
-		} else {
-			// If we could see the node but not anymore it means is out.
-			if stored.NAT == false && canConnectErr != nil {
-				// fmt.Println("RandomWalk LEFT!!", pID.String(), stored.NAT, canConnectErr)
-
-				c.updateCount(fmt.Sprintf("%s.left", currentDate()), true)
-				// c.updateCount(fmt.Sprintf("%s.count", currentDate()), false)
-				c.updateCount("total.count", false)
-				c.updateCount("total.left", false)
-
-				// Remove node from list
-				c.db.Delete([]byte(pID.String()), nil)
-
-			} else {
-				// If node already seen only update lastSeen
-				stored.lastSeen = timestamp
-				c.storeSeenNode(pID.String(), stored)
-			}
-		}
-		c.mux.Unlock()
+	wasReachable := wasConfirmedReachable(stored.Reachability)
+	// If we could see the node but not anymore it means is out.
+	if wasReachable && canConnectErr != nil {
+		c.updateCount(fmt.Sprintf("%s.left", currentDate()), true)
+		c.updateCount("total.count", false)
+		c.updateCount("total.left", false)
+
+		// Remove node from list
+		c.db.Delete([]byte(pInfo.ID.String()), nil)
 
+		return
 	}
+
+	// If node already seen only update lastSeen and reachability
+	stored.Reachability = reachabilityForPath(path)
+	stored.Path = path
+	stored.touch()
+	c.signAndStore(pInfo.ID.String(), stored)
 }
 
-// Make ephemeral connections to nodes.
-func (c *Crawler) ephemeralConnection(pInfo *peer.AddrInfo) error {
+// ephemeralConnection dials a peer, trying progressively more invasive NAT
+// traversal strategies until one works: a plain direct dial, then (if that
+// fails) a circuit-v2 relay from our configured relay set, then a DCUtR
+// hole punch attempt on top of the relayed connection. The strategy that
+// actually worked is returned with a nil error; when neither the direct
+// dial nor any relay works, PathNone is returned with the direct dial's
+// error.
+func (c *Crawler) ephemeralConnection(pInfo *peer.AddrInfo) (ConnectionPath, error) {
+	start := time.Now()
 
 	ctx, cancel := context.WithTimeout(context.Background(), timeEphemeralConnections*time.Second)
 
-	// TODO: Make a way of traversing NATs. Important
 	err := c.host.Connect(ctx, *pInfo)
 	errString := fmt.Sprintf("%v", err)
 	// If there is a context deadline, retry with a longer deadline.
@@ -324,17 +393,57 @@ func (c *Crawler) ephemeralConnection(pInfo *peer.AddrInfo) error {
 		err = c.host.Connect(ctx, *pInfo)
 		cancel()
 	}
-	// if err != nil {
-	// 	fmt.Fprintf(os.Stderr, "connecting to node: %s\n", err)
-	// } else {
-	// 	fmt.Println("Connected to", pInfo.ID)
-	// }
 	cancel()
 
-	return err
+	if err == nil {
+		c.recordTransportSuccess(pInfo.ID)
+		logger.Debugw("Connected", "peer", pInfo.ID, "result", string(PathDirect), "duration", time.Since(start))
+		return PathDirect, nil
+	}
+	c.recordTransportFailures(pInfo.Addrs)
+
+	// Direct dial failed: fall back to one of our configured relays.
+	relayCtx, relayCancel := context.WithTimeout(context.Background(), timeEphemeralConnections*time.Second)
+	relayErr := c.dialViaRelay(relayCtx, pInfo)
+	relayCancel()
+	if relayErr != nil {
+		logger.Debugw("Could not connect", "peer", pInfo.ID, "result", string(PathNone), "duration", time.Since(start), "err", err)
+		return PathNone, err
+	}
+
+	// Connected through a relay: see if DCUtR can upgrade it to direct.
+	punchCtx, punchCancel := context.WithTimeout(context.Background(), timeEphemeralConnections*time.Second)
+	punchErr := c.attemptHolePunch(punchCtx, pInfo.ID)
+	punchCancel()
+	if punchErr == nil {
+		logger.Debugw("Connected", "peer", pInfo.ID, "result", string(PathHolePunched), "duration", time.Since(start))
+		return PathHolePunched, nil
+	}
+
+	logger.Debugw("Connected", "peer", pInfo.ID, "result", string(PathRelayed), "duration", time.Since(start))
+	return PathRelayed, nil
+}
+
+// signAndStore signs a node record with the crawler's own libp2p identity
+// and persists it, so that datasets exported from this crawler (or merged
+// into another one, see the federation mode) can be verified.
+func (c *Crawler) signAndStore(key string, node *NodeRecord) {
+	priv := c.host.Peerstore().PrivKey(c.host.ID())
+	if err := node.sign(priv); err != nil {
+		logger.Warnw("Could not sign node record", "peer", key, "err", err)
+		return
+	}
+	c.storeSeenNode(key, node)
+
+	if c.federation != nil {
+		c.federation.publish(node)
+	}
 }
 
 func (c *Crawler) close() {
+	if c.federation != nil {
+		c.federation.close()
+	}
 	c.cancel()
 	c.host.Close()
 }
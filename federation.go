@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Tuning for the federation subsystem: how many records per source peer
+// we accept per window, how long another crawler's "reachable"
+// confirmation is trusted before we probe a peer ourselves again, and how
+// many distinct reporters must agree on a peer's state before we act on
+// it.
+const (
+	federationRateLimit  = 20
+	federationRateWindow = time.Minute
+	federationConfirmTTL = 10 * time.Minute
+
+	// federationQuorum is the number of distinct federation peers that
+	// must report the same Reachability for a subject peer, within
+	// federationRateWindow, before we let that report touch our own DB or
+	// short-circuit our own probe. A record's Signature only proves it
+	// was produced by whatever key signed it, not that the signer is
+	// entitled to speak for the peer it describes, so any single
+	// participant can mint a throwaway identity and vouch for an
+	// arbitrary victim PeerID. Requiring agreement from several
+	// independent reporters before trusting a claim is what actually
+	// raises the cost of that attack.
+	federationQuorum = 2
+)
+
+// FederationPeer lets several crawler instances share discoveries in real
+// time over a gossipsub topic: whenever this crawler signs and stores a
+// node record it publishes it, and subscribers treat incoming records as
+// corroborating signals rather than an authoritative source — a record is
+// only folded into our own DB, re-signed under our own identity, once
+// federationQuorum distinct reporters agree on it. It is opt-in, enabled
+// by passing a non-empty FederationTopic to initCrawler.
+type FederationPeer struct {
+	c      *Crawler
+	ps     *pubsub.PubSub
+	topic  *pubsub.Topic
+	sub    *pubsub.Subscription
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	rateMux     sync.Mutex
+	publishedBy map[peer.ID][]time.Time
+
+	reportsMux sync.Mutex
+	// reports holds, per subject PeerID, the latest report seen from each
+	// reporting peer within federationRateWindow.
+	reports map[string]map[peer.ID]federationReport
+
+	recentMux         sync.Mutex
+	recentlyConfirmed map[string]time.Time
+}
+
+// federationReport is one federation peer's claim about a subject node,
+// kept only long enough to check it against other reporters' claims.
+type federationReport struct {
+	node *NodeRecord
+	seen time.Time
+}
+
+// newFederationPeer joins topicName over gossipsub and starts consuming
+// records published by other federation peers.
+func newFederationPeer(ctx context.Context, c *Crawler, topicName string) (*FederationPeer, error) {
+	ps, err := pubsub.NewGossipSub(ctx, c.host)
+	if err != nil {
+		return nil, fmt.Errorf("creating gossipsub: %w", err)
+	}
+
+	topic, err := ps.Join(topicName)
+	if err != nil {
+		return nil, fmt.Errorf("joining federation topic %s: %w", topicName, err)
+	}
+
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return nil, fmt.Errorf("subscribing to federation topic %s: %w", topicName, err)
+	}
+
+	fctx, cancel := context.WithCancel(ctx)
+	f := &FederationPeer{
+		c:                 c,
+		ps:                ps,
+		topic:             topic,
+		sub:               sub,
+		ctx:               fctx,
+		cancel:            cancel,
+		publishedBy:       make(map[peer.ID][]time.Time),
+		reports:           make(map[string]map[peer.ID]federationReport),
+		recentlyConfirmed: make(map[string]time.Time),
+	}
+
+	go f.consume()
+
+	return f, nil
+}
+
+// publish announces a freshly discovered or updated node record to every
+// other federation peer on the topic.
+func (f *FederationPeer) publish(node *NodeRecord) {
+	data, err := marshalNodeRecord(node)
+	if err != nil {
+		logger.Warnw("Could not encode node record", "phase", phaseFederation, "err", err)
+		return
+	}
+	if err := f.topic.Publish(f.ctx, data); err != nil {
+		logger.Warnw("Could not publish node record", "phase", phaseFederation, "err", err)
+	}
+}
+
+// consume merges records published by other federation peers into our own
+// DB until the topic is closed.
+func (f *FederationPeer) consume() {
+	for {
+		msg, err := f.sub.Next(f.ctx)
+		if err != nil {
+			return
+		}
+
+		if !f.allow(msg.ReceivedFrom) {
+			continue
+		}
+
+		node, err := unmarshalNodeRecord(msg.Data)
+		if err != nil {
+			logger.Warnw("Dropping unreadable record", "phase", phaseFederation, "peer", msg.ReceivedFrom, "err", err)
+			continue
+		}
+
+		ok, err := node.verify()
+		if err != nil || !ok {
+			logger.Warnw("Dropping unverifiable record", "phase", phaseFederation, "peer", msg.ReceivedFrom)
+			continue
+		}
+
+		f.merge(msg.ReceivedFrom, node)
+	}
+}
+
+// allow applies a sliding-window rate limit per source peer ID, so a
+// malicious or misbehaving publisher cannot flood our DB.
+func (f *FederationPeer) allow(from peer.ID) bool {
+	f.rateMux.Lock()
+	defer f.rateMux.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-federationRateWindow)
+
+	kept := f.publishedBy[from][:0]
+	for _, t := range f.publishedBy[from] {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+
+	if len(kept) >= federationRateLimit {
+		f.publishedBy[from] = kept
+		return false
+	}
+
+	f.publishedBy[from] = append(kept, now)
+	return true
+}
+
+// merge records what `from` reported about node's subject peer and, once
+// federationQuorum distinct reporters agree on it within
+// federationRateWindow, folds the agreed view into our own DB. A remote
+// record's own Seq is never trusted as a conflict-resolution key — it is
+// fully controlled by whichever key signed the record, so instead we
+// re-sign the merged view under our own identity the same way a locally
+// observed update would be, and we never republish it, so federated
+// reports don't re-broadcast each other in a loop.
+func (f *FederationPeer) merge(from peer.ID, node *NodeRecord) {
+	agreed := f.recordReport(from, node)
+	if agreed == nil {
+		return
+	}
+
+	pid, err := peer.Decode(agreed.PeerID)
+	if err != nil {
+		logger.Warnw("Dropping record for unparsable peer id", "phase", phaseFederation, "peer", agreed.PeerID, "err", err)
+		return
+	}
+
+	f.c.mux.Lock()
+	existing, err := f.c.getSeenNode(agreed.PeerID)
+	if err != nil || existing == nil {
+		existing = newNodeRecord(pid)
+	}
+	existing.Addrs = agreed.Addrs
+	existing.Protocols = agreed.Protocols
+	existing.AgentVersion = agreed.AgentVersion
+	existing.Reachability = agreed.Reachability
+	existing.Path = agreed.Path
+	existing.touch()
+
+	priv := f.c.host.Peerstore().PrivKey(f.c.host.ID())
+	if err := existing.sign(priv); err != nil {
+		logger.Warnw("Could not sign merged node record", "phase", phaseFederation, "peer", agreed.PeerID, "err", err)
+		f.c.mux.Unlock()
+		return
+	}
+	f.c.storeSeenNode(agreed.PeerID, existing)
+	f.c.mux.Unlock()
+
+	if agreed.Reachability == ReachabilityPublic || agreed.Reachability == ReachabilityRelayed {
+		f.recentMux.Lock()
+		f.recentlyConfirmed[agreed.PeerID] = time.Now()
+		f.recentMux.Unlock()
+	}
+}
+
+// recordReport tracks node as from's latest claim about its subject peer
+// and, once at least federationQuorum distinct reporters currently agree
+// on the same Reachability for that peer within federationRateWindow,
+// returns the agreed-upon record. Stale reports (older than the window)
+// are pruned as they're encountered.
+func (f *FederationPeer) recordReport(from peer.ID, node *NodeRecord) *NodeRecord {
+	f.reportsMux.Lock()
+	defer f.reportsMux.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-federationRateWindow)
+
+	bySubject := f.reports[node.PeerID]
+	if bySubject == nil {
+		bySubject = make(map[peer.ID]federationReport)
+		f.reports[node.PeerID] = bySubject
+	}
+	bySubject[from] = federationReport{node: node, seen: now}
+
+	agreeing := 0
+	for reporter, report := range bySubject {
+		if report.seen.Before(cutoff) {
+			delete(bySubject, reporter)
+			continue
+		}
+		if report.node.Reachability == node.Reachability {
+			agreeing++
+		}
+	}
+
+	if agreeing < federationQuorum {
+		return nil
+	}
+	return node
+}
+
+// recentlyConfirmedReachable reports whether another federation peer
+// confirmed pid reachable within federationConfirmTTL.
+func (f *FederationPeer) recentlyConfirmedReachable(pid string) bool {
+	f.recentMux.Lock()
+	defer f.recentMux.Unlock()
+
+	t, ok := f.recentlyConfirmed[pid]
+	if !ok {
+		return false
+	}
+	if time.Since(t) > federationConfirmTTL {
+		delete(f.recentlyConfirmed, pid)
+		return false
+	}
+	return true
+}
+
+func (f *FederationPeer) close() {
+	f.cancel()
+	f.sub.Cancel()
+	f.topic.Close()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func newTestPeerID(t *testing.T) peer.ID {
+	t.Helper()
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	pid, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatalf("deriving peer id: %v", err)
+	}
+	return pid
+}
+
+func newTestFederationPeer() *FederationPeer {
+	return &FederationPeer{
+		publishedBy: make(map[peer.ID][]time.Time),
+		reports:     make(map[string]map[peer.ID]federationReport),
+	}
+}
+
+func TestAllowRateLimitsPerSourcePeer(t *testing.T) {
+	f := newTestFederationPeer()
+	from := newTestPeerID(t)
+
+	for i := 0; i < federationRateLimit; i++ {
+		if !f.allow(from) {
+			t.Fatalf("allow rejected report %d, want it within the %d budget", i, federationRateLimit)
+		}
+	}
+	if f.allow(from) {
+		t.Fatal("allow let a source peer exceed federationRateLimit within the window")
+	}
+
+	// A different source peer has its own independent budget.
+	other := newTestPeerID(t)
+	if !f.allow(other) {
+		t.Fatal("allow incorrectly shared budget across distinct source peers")
+	}
+}
+
+func TestRecordReportRequiresDistinctReporterQuorum(t *testing.T) {
+	f := newTestFederationPeer()
+	subject := newTestPeerID(t)
+
+	record := newNodeRecord(subject)
+	record.Reachability = ReachabilityPublic
+
+	reporterA := newTestPeerID(t)
+	reporterB := newTestPeerID(t)
+
+	if got := f.recordReport(reporterA, record); got != nil {
+		t.Fatal("a single reporter should not reach quorum on its own")
+	}
+
+	// The same reporter repeating its claim must not count twice toward
+	// quorum: this is exactly what an attacker with one throwaway
+	// identity could otherwise exploit to vouch for an arbitrary victim
+	// peer on its own.
+	if got := f.recordReport(reporterA, record); got != nil {
+		t.Fatal("a repeated report from the same reporter should not satisfy quorum")
+	}
+
+	if got := f.recordReport(reporterB, record); got == nil {
+		t.Fatal("expected quorum once a second, distinct reporter agreed")
+	}
+}
+
+func TestRecordReportIgnoresDisagreeingReporters(t *testing.T) {
+	f := newTestFederationPeer()
+	subject := newTestPeerID(t)
+
+	publicRecord := newNodeRecord(subject)
+	publicRecord.Reachability = ReachabilityPublic
+
+	offlineRecord := newNodeRecord(subject)
+	offlineRecord.Reachability = ReachabilityOffline
+
+	reporterA := newTestPeerID(t)
+	reporterB := newTestPeerID(t)
+
+	f.recordReport(reporterA, publicRecord)
+	if got := f.recordReport(reporterB, offlineRecord); got != nil {
+		t.Fatal("disagreeing reporters should not combine into a quorum for either claim")
+	}
+}
+
+func TestRecordReportTracksSubjectsIndependently(t *testing.T) {
+	f := newTestFederationPeer()
+	victim := newTestPeerID(t)
+	other := newTestPeerID(t)
+
+	victimRecord := newNodeRecord(victim)
+	victimRecord.Reachability = ReachabilityPublic
+
+	otherRecord := newNodeRecord(other)
+	otherRecord.Reachability = ReachabilityPublic
+
+	reporter := newTestPeerID(t)
+
+	f.recordReport(reporter, victimRecord)
+	// Only one (victim, reporter) pair has been recorded; a single
+	// attacker-controlled reporter must not be able to reach quorum for
+	// an unrelated subject peer just because it already reported on
+	// another one.
+	if got := f.recordReport(reporter, otherRecord); got != nil {
+		t.Fatal("quorum for one subject peer leaked into an unrelated one")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// startIdentifyConsumer subscribes to the host's identify events and keeps
+// node records in sync with what identify actually observed. This
+// replaces the NAT=true/false heuristic ("did host.Connect succeed") with
+// a proper reachability field, and fixes the race where a record used to
+// be written from a Connect error before identify had even run: a record
+// is now only created or enriched once identification has completed (or
+// definitively failed) for a peer.
+func (c *Crawler) startIdentifyConsumer() {
+	sub, err := c.host.EventBus().Subscribe([]interface{}{
+		new(event.EvtPeerIdentificationCompleted),
+		new(event.EvtPeerIdentificationFailed),
+	})
+	if err != nil {
+		logger.Warnw("Could not subscribe to identify events", "phase", phaseIdentify, "err", err)
+		return
+	}
+
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case evt, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				switch e := evt.(type) {
+				case event.EvtPeerIdentificationCompleted:
+					c.onIdentifyCompleted(e)
+				case event.EvtPeerIdentificationFailed:
+					c.onIdentifyFailed(e)
+				}
+			}
+		}
+	}()
+}
+
+// onIdentifyCompleted merges what identify learned about a peer into its
+// stored NodeRecord, creating the record on first contact.
+func (c *Crawler) onIdentifyCompleted(e event.EvtPeerIdentificationCompleted) {
+	pid := e.Peer
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	node, err := c.getSeenNode(pid.String())
+	if err != nil || node == nil {
+		node = newNodeRecord(pid)
+		c.updateCount(fmt.Sprintf("%s.count", currentDate()), true)
+		c.updateCount("total.count", true)
+	}
+
+	node.Addrs = addrsToStrings(c.host.Peerstore().Addrs(pid))
+	if protos, err := c.host.Peerstore().GetProtocols(pid); err == nil {
+		node.Protocols = protos
+	}
+	node.AgentVersion = peerAgentVersion(c.host.Peerstore(), pid)
+	// Classify reachability from the connection identify actually ran
+	// over, not a flat "identify completed, so it must be public":
+	// a circuit-relayed connection still means the peer needed help to
+	// reach, and one that has since dropped means we can't presently say
+	// more than "behind a NAT we can't traverse".
+	switch {
+	case c.host.Network().Connectedness(pid) != network.Connected:
+		node.Reachability = ReachabilityBehindNAT
+	case c.hasDirectConnection(pid):
+		node.Reachability = ReachabilityPublic
+	default:
+		node.Reachability = ReachabilityRelayed
+	}
+	node.touch()
+
+	c.signAndStore(pid.String(), node)
+}
+
+// onIdentifyFailed marks a peer as offline. It does not create a record
+// for a peer we have never successfully identified before.
+func (c *Crawler) onIdentifyFailed(e event.EvtPeerIdentificationFailed) {
+	pid := e.Peer
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	node, err := c.getSeenNode(pid.String())
+	if err != nil || node == nil {
+		// Never identified this peer before: nothing to downgrade.
+		return
+	}
+
+	node.Reachability = ReachabilityOffline
+	node.touch()
+	c.signAndStore(pid.String(), node)
+}
+
+func addrsToStrings(addrs []multiaddr.Multiaddr) []string {
+	out := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		out = append(out, a.String())
+	}
+	return out
+}
+
+func peerAgentVersion(ps peerstore.Peerstore, pid peer.ID) string {
+	v, err := ps.Get(pid, "AgentVersion")
+	if err != nil {
+		return ""
+	}
+	s, _ := v.(string)
+	return s
+}
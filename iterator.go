@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Iterator yields peers to probe from some discovery source. It is modeled
+// on enode.Iterator: repeated calls to Next advance the iterator and
+// report whether a peer is available, and Close releases its resources and
+// makes all blocked and future calls to Next return false.
+type Iterator interface {
+	// Next blocks until a peer is available, ctx is done, or the iterator
+	// is closed. It returns false once no more peers will ever be produced.
+	Next(ctx context.Context) (peer.AddrInfo, bool)
+	// Close ends the iterator.
+	Close()
+}
+
+// randomWalkIterator drives the DHT random walk: each time its queue runs
+// dry it performs a GetClosestPeers lookup against a freshly chosen random
+// peer ID and streams the results.
+type randomWalkIterator struct {
+	c      *Crawler
+	queue  []peer.ID
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newRandomWalkIterator(c *Crawler) *randomWalkIterator {
+	return &randomWalkIterator{c: c, closed: make(chan struct{})}
+}
+
+func (it *randomWalkIterator) Next(ctx context.Context) (peer.AddrInfo, bool) {
+	for {
+		select {
+		case <-it.closed:
+			return peer.AddrInfo{}, false
+		case <-ctx.Done():
+			return peer.AddrInfo{}, false
+		default:
+		}
+
+		if len(it.queue) == 0 {
+			id, err := test.RandPeerID()
+			if err != nil {
+				return peer.AddrInfo{}, false
+			}
+
+			lookupCtx, cancel := context.WithTimeout(ctx, timeClosestPeers*time.Second)
+			pch, _ := it.c.dht.GetClosestPeers(lookupCtx, id.String())
+			for p := range pch {
+				it.queue = append(it.queue, p)
+			}
+			cancel()
+
+			// Go round again so a Close or ctx cancellation that arrived
+			// during the lookup is seen before a peer is handed out.
+			continue
+		}
+
+		next := it.queue[0]
+		it.queue = it.queue[1:]
+		return peer.AddrInfo{ID: next}, true
+	}
+}
+
+func (it *randomWalkIterator) Close() {
+	it.once.Do(func() { close(it.closed) })
+}
+
+// peerstoreIterator drains peer.AddrInfo records already known to the
+// host's own peerstore, e.g. peers learned as a side effect of DHT traffic
+// that a pure random walk would never revisit.
+type peerstoreIterator struct {
+	c      *Crawler
+	ids    []peer.ID
+	pos    int
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPeerstoreIterator(c *Crawler) *peerstoreIterator {
+	return &peerstoreIterator{c: c, closed: make(chan struct{})}
+}
+
+// peerstorePollInterval is how long Next waits before re-checking the
+// peerstore after finding it momentarily empty.
+const peerstorePollInterval = 2 * time.Second
+
+func (it *peerstoreIterator) Next(ctx context.Context) (peer.AddrInfo, bool) {
+	for {
+		select {
+		case <-it.closed:
+			return peer.AddrInfo{}, false
+		case <-ctx.Done():
+			return peer.AddrInfo{}, false
+		default:
+		}
+
+		if it.pos >= len(it.ids) {
+			it.ids = it.c.host.Peerstore().PeersWithAddrs()
+			it.pos = 0
+			if len(it.ids) == 0 {
+				// The peerstore being empty right now is transient, not
+				// "no more peers will ever be produced": wait and check
+				// again instead of signaling permanent exhaustion, which
+				// would make FairMix.runSource retire this source for
+				// the rest of the crawl.
+				select {
+				case <-time.After(peerstorePollInterval):
+					continue
+				case <-it.closed:
+					return peer.AddrInfo{}, false
+				case <-ctx.Done():
+					return peer.AddrInfo{}, false
+				}
+			}
+		}
+
+		id := it.ids[it.pos]
+		it.pos++
+		return it.c.host.Peerstore().PeerInfo(id), true
+	}
+}
+
+func (it *peerstoreIterator) Close() {
+	it.once.Do(func() { close(it.closed) })
+}
+
+// seedFileIterator reads a static list of bootstrap/seed multiaddrs from
+// disk, one per line, and yields each of them exactly once.
+type seedFileIterator struct {
+	addrs  []peer.AddrInfo
+	pos    int
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newSeedFileIterator(path string) (*seedFileIterator, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	it := &seedFileIterator{closed: make(chan struct{})}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		a, err := multiaddr.NewMultiaddr(line)
+		if err != nil {
+			continue
+		}
+		pInfo, err := peer.AddrInfoFromP2pAddr(a)
+		if err != nil {
+			continue
+		}
+		it.addrs = append(it.addrs, *pInfo)
+	}
+
+	return it, nil
+}
+
+func (it *seedFileIterator) Next(ctx context.Context) (peer.AddrInfo, bool) {
+	select {
+	case <-it.closed:
+		return peer.AddrInfo{}, false
+	case <-ctx.Done():
+		return peer.AddrInfo{}, false
+	default:
+	}
+
+	if it.pos >= len(it.addrs) {
+		return peer.AddrInfo{}, false
+	}
+	a := it.addrs[it.pos]
+	it.pos++
+	return a, true
+}
+
+func (it *seedFileIterator) Close() {
+	it.once.Do(func() { close(it.closed) })
+}
+
+// Bounds on the per-source timeout FairMix adapts between.
+const (
+	fairMixDefaultTimeout = 1 * time.Second
+	fairMixMinTimeout     = 50 * time.Millisecond
+	fairMixMaxTimeout     = 10 * time.Second
+)
+
+// fairMixSource wraps one Iterator with a background goroutine feeding a
+// channel, plus the adaptive timeout FairMix uses when polling it.
+type fairMixSource struct {
+	it      Iterator
+	next    chan peer.AddrInfo
+	timeout time.Duration
+}
+
+// FairMix multiplexes several Iterators into one, giving each source a
+// fair turn every round while adapting a per-source timeout: a source that
+// keeps answering quickly is polled with a shorter timeout, one that is
+// slow or temporarily dry gets more slack before it is skipped for the
+// round.
+type FairMix struct {
+	sources []*fairMixSource
+	cur     int
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// NewFairMix starts a background reader for each iterator and returns the
+// combined Iterator.
+func NewFairMix(iterators ...Iterator) *FairMix {
+	mix := &FairMix{closed: make(chan struct{})}
+	for _, it := range iterators {
+		src := &fairMixSource{it: it, next: make(chan peer.AddrInfo), timeout: fairMixDefaultTimeout}
+		mix.sources = append(mix.sources, src)
+		go mix.runSource(src)
+	}
+	return mix
+}
+
+func (mix *FairMix) runSource(src *fairMixSource) {
+	for {
+		info, ok := src.it.Next(context.Background())
+		if !ok {
+			return
+		}
+		select {
+		case src.next <- info:
+		case <-mix.closed:
+			return
+		}
+	}
+}
+
+// Next polls each source in round-robin order until one produces a peer,
+// ctx is done, or the mix is closed. A source timing out for one round
+// just means it's slow or temporarily dry, not that the mix is done, so
+// Next keeps cycling through sources rather than giving up after a single
+// pass. Exhausted sources are not tracked: once every source has run dry,
+// Next keeps polling until ctx is done or the mix is closed, and does not
+// return false on its own.
+func (mix *FairMix) Next(ctx context.Context) (peer.AddrInfo, bool) {
+	if len(mix.sources) == 0 {
+		return peer.AddrInfo{}, false
+	}
+
+	for {
+		src := mix.sources[mix.cur]
+		mix.cur = (mix.cur + 1) % len(mix.sources)
+
+		start := time.Now()
+		timer := time.NewTimer(src.timeout)
+		select {
+		case info, ok := <-src.next:
+			timer.Stop()
+			if !ok {
+				continue
+			}
+			// Source answered quickly: shrink its timeout so it gets
+			// polled more eagerly next round.
+			src.timeout = shrinkTimeout(src.timeout, time.Since(start))
+			return info, true
+		case <-timer.C:
+			// Source is slow or has nothing right now: give it more
+			// slack next time and move on to the next source this round.
+			src.timeout = growTimeout(src.timeout)
+		case <-ctx.Done():
+			timer.Stop()
+			return peer.AddrInfo{}, false
+		case <-mix.closed:
+			timer.Stop()
+			return peer.AddrInfo{}, false
+		}
+	}
+}
+
+func shrinkTimeout(cur, took time.Duration) time.Duration {
+	t := took * 2
+	if t < fairMixMinTimeout {
+		t = fairMixMinTimeout
+	}
+	if t > cur {
+		t = cur
+	}
+	return t
+}
+
+func growTimeout(cur time.Duration) time.Duration {
+	t := cur * 2
+	if t > fairMixMaxTimeout {
+		t = fairMixMaxTimeout
+	}
+	return t
+}
+
+// Close stops every underlying source and makes future calls to Next
+// return false.
+func (mix *FairMix) Close() {
+	mix.once.Do(func() {
+		close(mix.closed)
+		for _, src := range mix.sources {
+			src.it.Close()
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestShrinkTimeoutNeverExceedsCurrentOrGoesBelowMin(t *testing.T) {
+	got := shrinkTimeout(fairMixDefaultTimeout, 10*time.Millisecond)
+	if got != 20*time.Millisecond {
+		t.Fatalf("shrinkTimeout(%v, 10ms) = %v, want 20ms", fairMixDefaultTimeout, got)
+	}
+
+	got = shrinkTimeout(fairMixDefaultTimeout, time.Microsecond)
+	if got != fairMixMinTimeout {
+		t.Fatalf("shrinkTimeout should floor at fairMixMinTimeout, got %v", got)
+	}
+
+	got = shrinkTimeout(50*time.Millisecond, time.Hour)
+	if got != 50*time.Millisecond {
+		t.Fatalf("shrinkTimeout should never exceed the current timeout, got %v", got)
+	}
+}
+
+func TestGrowTimeoutCapsAtMax(t *testing.T) {
+	got := growTimeout(fairMixMaxTimeout / 2)
+	if got != fairMixMaxTimeout {
+		t.Fatalf("growTimeout(%v) = %v, want %v", fairMixMaxTimeout/2, got, fairMixMaxTimeout)
+	}
+
+	got = growTimeout(fairMixMaxTimeout)
+	if got != fairMixMaxTimeout {
+		t.Fatalf("growTimeout should not exceed fairMixMaxTimeout, got %v", got)
+	}
+}
+
+// slowIterator answers its first N calls only after a delay longer than
+// fairMixDefaultTimeout, then yields normally, so FairMix.Next has to
+// survive a few timed-out rounds before it can return anything.
+type slowIterator struct {
+	delayedCalls int
+	delay        time.Duration
+	closed       chan struct{}
+}
+
+func newSlowIterator(delayedCalls int, delay time.Duration) *slowIterator {
+	return &slowIterator{delayedCalls: delayedCalls, delay: delay, closed: make(chan struct{})}
+}
+
+func (it *slowIterator) Next(ctx context.Context) (peer.AddrInfo, bool) {
+	if it.delayedCalls > 0 {
+		it.delayedCalls--
+		select {
+		case <-time.After(it.delay):
+		case <-ctx.Done():
+			return peer.AddrInfo{}, false
+		}
+	}
+	select {
+	case <-it.closed:
+		return peer.AddrInfo{}, false
+	default:
+	}
+	return peer.AddrInfo{}, true
+}
+
+func (it *slowIterator) Close() {
+	select {
+	case <-it.closed:
+	default:
+		close(it.closed)
+	}
+}
+
+func TestFairMixNextSurvivesASlowRound(t *testing.T) {
+	// Every source times out on the first round; FairMix.Next must keep
+	// cycling rather than reporting itself permanently exhausted.
+	mix := NewFairMix(newSlowIterator(1, fairMixDefaultTimeout+200*time.Millisecond))
+	defer mix.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, ok := mix.Next(ctx)
+	if !ok {
+		t.Fatal("Next gave up after a slow round instead of waiting for the source to answer")
+	}
+}
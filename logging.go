@@ -0,0 +1,34 @@
+package main
+
+import (
+	golog "github.com/ipfs/go-log/v2"
+)
+
+// logger emits every crawler event through fixed, filterable keys
+// (peer, addr, transport, err, duration, phase, nat, result) instead of
+// the free-form "[Random Walk] New Node: " + pID + aux strings this
+// package used to print.
+var logger = golog.Logger("crawler")
+
+// Crawl phases, used as the "phase" log key.
+const (
+	phaseRandomWalk = "randomwalk"
+	phaseLiveliness = "liveliness"
+	phaseBootstrap  = "bootstrap"
+	phaseIdentify   = "identify"
+	phaseFederation = "federation"
+)
+
+// setLogLevel wires up the --loglevel flag (trace/debug/info/warn) against
+// the crawler's logger, replacing the verbose bool that used to be passed
+// down every call chain. "trace" maps to go-log's "debug", which is its
+// most verbose level.
+func setLogLevel(level string) {
+	if level == "trace" {
+		level = "debug"
+	}
+	if err := golog.SetLogLevel("crawler", level); err != nil {
+		logger.Warnw("Invalid log level, defaulting to info", "level", level, "err", err)
+		golog.SetLogLevel("crawler", "info")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// ConnectionPath records which strategy actually produced a working
+// connection to a peer: a plain direct dial, a circuit-v2 relay, or a
+// DCUtR hole punch that upgraded a relayed connection to a direct one.
+type ConnectionPath string
+
+const (
+	PathDirect      ConnectionPath = "direct"
+	PathRelayed     ConnectionPath = "relayed"
+	PathHolePunched ConnectionPath = "hole-punched"
+	PathNone        ConnectionPath = "none"
+)
+
+// dcutrProtocolID is the hole-punching protocol a peer must speak before
+// we bother waiting for DCUtR to upgrade a relayed connection.
+const dcutrProtocolID = "/libp2p/dcutr"
+
+// relayAddrInfos resolves a list of relay multiaddrs (the same "/p2p/..."
+// shape used for BootstrapNodes) into AddrInfos the host can dial
+// through, for use as libp2p's static relay set.
+func relayAddrInfos(relayNodes []string) []peer.AddrInfo {
+	var relays []peer.AddrInfo
+	for _, pString := range relayNodes {
+		a, err := multiaddr.NewMultiaddr(pString)
+		if err != nil {
+			continue
+		}
+		pInfo, err := peer.AddrInfoFromP2pAddr(a)
+		if err != nil {
+			continue
+		}
+		relays = append(relays, *pInfo)
+	}
+	return relays
+}
+
+// dialViaRelay tries to reach a peer through one of the crawler's
+// configured relays by composing a circuit-v2 address for it.
+func (c *Crawler) dialViaRelay(ctx context.Context, pInfo *peer.AddrInfo) error {
+	var lastErr error
+	for _, relay := range c.relayNodes {
+		circuit, err := multiaddr.NewMultiaddr(fmt.Sprintf("/p2p/%s/p2p-circuit/p2p/%s", relay.ID, pInfo.ID))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		relayed := peer.AddrInfo{ID: pInfo.ID, Addrs: []multiaddr.Multiaddr{circuit}}
+		if err := c.host.Connect(ctx, relayed); err != nil {
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no relays configured")
+	}
+	return lastErr
+}
+
+// attemptHolePunch polls for DCUtR upgrading an existing relayed
+// connection to a direct one, returning nil as soon as the peer shows up
+// as directly connected, or the context's error once it expires. It
+// returns an error straight away, without polling, when the peer's
+// protocols are unknown or do not include DCUtR.
+func (c *Crawler) attemptHolePunch(ctx context.Context, pid peer.ID) error {
+	protos, err := c.host.Peerstore().GetProtocols(pid)
+	if err != nil {
+		return err
+	}
+	if !supportsDCUtR(protos) {
+		return fmt.Errorf("peer %s does not support hole punching", pid)
+	}
+
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if c.hasDirectConnection(pid) {
+				return nil
+			}
+		}
+	}
+}
+
+// hasDirectConnection reports whether any current connection to pid is a
+// plain transport connection rather than a circuit-relayed one.
+func (c *Crawler) hasDirectConnection(pid peer.ID) bool {
+	for _, conn := range c.host.Network().ConnsToPeer(pid) {
+		if _, err := conn.RemoteMultiaddr().ValueForProtocol(multiaddr.P_CIRCUIT); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// reachabilityForPath derives a Reachability classification from which
+// strategy actually produced (or failed to produce) a connection, instead
+// of inferring it from a bare Connect error: only a plain direct dial
+// means the peer is publicly reachable on its own; a relayed connection,
+// hole-punched or not, still required another peer's help to set up, so
+// both map to ReachabilityRelayed; no connection at all (direct dial and
+// every configured relay both failed) means the peer looks behind a NAT
+// we can't presently traverse.
+func reachabilityForPath(path ConnectionPath) Reachability {
+	switch path {
+	case PathDirect:
+		return ReachabilityPublic
+	case PathRelayed, PathHolePunched:
+		return ReachabilityRelayed
+	case PathNone:
+		return ReachabilityBehindNAT
+	default:
+		return ReachabilityUnknown
+	}
+}
+
+// wasConfirmedReachable mirrors the old NAT==false check: true only for a
+// Reachability that means some path to the peer actually worked.
+// ReachabilityBehindNAT means the opposite — every path we tried failed —
+// so it must not count as "was reachable" alongside Public/Relayed.
+func wasConfirmedReachable(r Reachability) bool {
+	return r == ReachabilityPublic || r == ReachabilityRelayed
+}
+
+func supportsDCUtR(protocols []string) bool {
+	for _, p := range protocols {
+		if p == dcutrProtocolID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Reachability classifies how a peer could be reached the last time we
+// probed it.
+type Reachability int
+
+const (
+	ReachabilityUnknown Reachability = iota
+	ReachabilityPublic
+	ReachabilityBehindNAT
+	ReachabilityRelayed
+	ReachabilityOffline
+)
+
+func (r Reachability) String() string {
+	switch r {
+	case ReachabilityPublic:
+		return "public"
+	case ReachabilityBehindNAT:
+		return "behind-nat"
+	case ReachabilityRelayed:
+		return "relayed"
+	case ReachabilityOffline:
+		return "offline"
+	default:
+		return "unknown"
+	}
+}
+
+// NodeRecord is the signed, versioned record we keep for every peer we have
+// ever seen. It is modeled loosely on an Ethereum ENR: a monotonically
+// increasing Seq is bumped on every change, and a signature over the
+// encoded payload shows which key produced this version. The signature
+// does not show that the signer may speak for PeerID, and federation
+// merges do not use a remote record's Seq; see FederationPeer.
+type NodeRecord struct {
+	PeerID       string       `cbor:"peer_id"`
+	Addrs        []string     `cbor:"addrs"`
+	Protocols    []string     `cbor:"protocols"`
+	AgentVersion string       `cbor:"agent_version"`
+	PublicKey    []byte       `cbor:"public_key"`
+	FirstSeen    int64        `cbor:"first_seen"`
+	LastSeen     int64        `cbor:"last_seen"`
+	Reachability Reachability `cbor:"reachability"`
+	// Path records which strategy last produced a working connection:
+	// direct dial, a circuit-v2 relay, or a DCUtR hole punch.
+	Path ConnectionPath `cbor:"path"`
+	Seq  uint64         `cbor:"seq"`
+
+	// Signature is computed over the CBOR encoding of the record with
+	// Signature left empty (SignedBy is filled in first, so it is covered
+	// too), signed by the crawler's own libp2p identity.
+	Signature []byte `cbor:"signature"`
+	SignedBy  []byte `cbor:"signed_by"`
+}
+
+// newNodeRecord creates the first version of a record for a freshly
+// discovered peer.
+func newNodeRecord(p peer.ID) *NodeRecord {
+	now := time.Now().UTC().UnixNano()
+	return &NodeRecord{
+		PeerID:       p.String(),
+		FirstSeen:    now,
+		LastSeen:     now,
+		Reachability: ReachabilityUnknown,
+		Seq:          1,
+	}
+}
+
+// touch bumps Seq and LastSeen. Call after mutating any other field.
+func (n *NodeRecord) touch() {
+	n.Seq++
+	n.LastSeen = time.Now().UTC().UnixNano()
+}
+
+// sign encodes the record and signs it with priv, filling in Signature and
+// SignedBy.
+func (n *NodeRecord) sign(priv crypto.PrivKey) error {
+	n.Signature = nil
+
+	pub, err := crypto.MarshalPublicKey(priv.GetPublic())
+	if err != nil {
+		return fmt.Errorf("marshaling public key: %w", err)
+	}
+	n.SignedBy = pub
+
+	payload, err := cbor.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("encoding node record: %w", err)
+	}
+
+	sig, err := priv.Sign(payload)
+	if err != nil {
+		return fmt.Errorf("signing node record: %w", err)
+	}
+	n.Signature = sig
+	return nil
+}
+
+// verify checks that Signature was produced by SignedBy over the rest of
+// the record.
+func (n *NodeRecord) verify() (bool, error) {
+	pub, err := crypto.UnmarshalPublicKey(n.SignedBy)
+	if err != nil {
+		return false, fmt.Errorf("unmarshaling public key: %w", err)
+	}
+
+	unsigned := *n
+	unsigned.Signature = nil
+	payload, err := cbor.Marshal(&unsigned)
+	if err != nil {
+		return false, fmt.Errorf("encoding node record: %w", err)
+	}
+
+	return pub.Verify(payload, n.Signature)
+}
+
+// marshalNodeRecord serializes a record for storage in leveldb.
+func marshalNodeRecord(n *NodeRecord) ([]byte, error) {
+	return cbor.Marshal(n)
+}
+
+// unmarshalNodeRecord deserializes a record read back from leveldb.
+func unmarshalNodeRecord(data []byte) (*NodeRecord, error) {
+	var n NodeRecord
+	if err := cbor.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestNodeRecordSignVerifyRoundTrip(t *testing.T) {
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	pid, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatalf("deriving peer id: %v", err)
+	}
+
+	n := newNodeRecord(pid)
+	if err := n.sign(priv); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	ok, err := n.verify()
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if !ok {
+		t.Fatal("expected a record signed by its own SignedBy key to verify")
+	}
+}
+
+func TestNodeRecordVerifyRejectsTamperedField(t *testing.T) {
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	pid, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatalf("deriving peer id: %v", err)
+	}
+
+	n := newNodeRecord(pid)
+	if err := n.sign(priv); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	n.AgentVersion = "tampered"
+
+	ok, err := n.verify()
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if ok {
+		t.Fatal("expected verify to fail once a signed field is changed")
+	}
+}
+
+func TestNodeRecordVerifyAcceptsUnrelatedSigner(t *testing.T) {
+	subjectPriv, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatalf("generating subject key: %v", err)
+	}
+
+	attackerPriv, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatalf("generating attacker key: %v", err)
+	}
+
+	subjectPid, err := peer.IDFromPrivateKey(subjectPriv)
+	if err != nil {
+		t.Fatalf("deriving subject peer id: %v", err)
+	}
+
+	// An attacker mints a record claiming to describe subjectPid but
+	// signs it with its own unrelated key, exactly what an unauthorized
+	// federation publisher would do.
+	n := newNodeRecord(subjectPid)
+	if err := n.sign(attackerPriv); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	ok, err := n.verify()
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if !ok {
+		t.Fatal("verify only checks internal self-consistency, so this is expected to pass")
+	}
+	// verify() proves SignedBy produced Signature, nothing more: it says
+	// nothing about whether SignedBy is entitled to vouch for PeerID.
+	// That's why federation quorum (not a bare verify() pass) gates
+	// whether a report can influence our own DB — see federation_test.go.
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// transportLabel identifies which transport a multiaddr belongs to, for
+// the per-transport success/failure counters.
+func transportLabel(addr multiaddr.Multiaddr) string {
+	switch {
+	case hasProtocol(addr, multiaddr.P_QUIC_V1), hasProtocol(addr, multiaddr.P_QUIC):
+		return "quic"
+	case hasProtocol(addr, multiaddr.P_WS):
+		return "ws"
+	case hasProtocol(addr, multiaddr.P_TCP):
+		return "tcp"
+	default:
+		return "other"
+	}
+}
+
+func hasProtocol(addr multiaddr.Multiaddr, code int) bool {
+	for _, p := range addr.Protocols() {
+		if p.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// recordTransportSuccess bumps the counter for whichever transport
+// actually produced a live connection to pid, so operators can see which
+// transports are producing reachable peers.
+func (c *Crawler) recordTransportSuccess(pid peer.ID) {
+	label := "other"
+	if conns := c.host.Network().ConnsToPeer(pid); len(conns) > 0 {
+		label = transportLabel(conns[0].RemoteMultiaddr())
+	}
+	c.updateCount(fmt.Sprintf("transport.%s.success", label), true)
+}
+
+// recordTransportFailures bumps the failure counter for every transport we
+// attempted when a direct dial to addrs did not produce a connection.
+func (c *Crawler) recordTransportFailures(addrs []multiaddr.Multiaddr) {
+	seen := map[string]bool{}
+	for _, a := range addrs {
+		label := transportLabel(a)
+		if seen[label] {
+			continue
+		}
+		seen[label] = true
+		c.updateCount(fmt.Sprintf("transport.%s.failure", label), true)
+	}
+}